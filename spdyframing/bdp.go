@@ -0,0 +1,85 @@
+package spdyframing
+
+import "time"
+
+// This file implements automatic growth of the per-stream initial
+// flow-control window based on an estimate of the bandwidth-delay
+// product of the underlying connection, following the approach
+// used by grpc-go: time how long it takes a PING sent at the start
+// of a burst of DATA to be acked, and treat the bytes received
+// during that round trip as one BDP sample.
+
+// bdpSampleGamma accounts for a PING/ack round trip only observing
+// one direction's worth of in-flight data.
+const bdpSampleGamma = 2
+
+// bdpSmoothing controls how quickly the running estimate forgets
+// old samples.
+const bdpSmoothing = 0.9
+
+// bdpOnData is called as DATA frames are received. It starts a new
+// BDP sample at most once per estimated RTT, and accumulates bytes
+// for whichever sample is in flight.
+func (s *Session) bdpOnData(n int) {
+	if s.DisableBDPEstimation || n == 0 {
+		return
+	}
+	if !s.bdpSampling {
+		if !s.bdpLastSampleAt.IsZero() && time.Since(s.bdpLastSampleAt) < s.bdpRTT {
+			return
+		}
+		s.bdpSampling = true
+		s.bdpSampleBytes = 0
+		if _, err := s.sendTrackedPing(pingKindBDP, nil); err != nil {
+			s.bdpSampling = false
+		}
+	}
+	s.bdpSampleBytes += int32(n)
+}
+
+// bdpSampleDone is called from handlePing once the ack for a
+// BDP-sampling PING arrives, completing the sample started in
+// bdpOnData.
+func (s *Session) bdpSampleDone(rtt time.Duration) {
+	s.bdpSampling = false
+	s.bdpLastSampleAt = time.Now()
+	s.bdpRTT = rtt
+
+	sample := float64(s.bdpSampleBytes) * bdpSampleGamma
+	if sample > s.bdpMax {
+		s.bdpMax = sample
+	} else {
+		s.bdpMax = s.bdpMax*bdpSmoothing + sample*(1-bdpSmoothing)
+	}
+
+	if wnd := int32(s.bdpMax); wnd > s.recvInitWnd {
+		s.growInitWnd(wnd)
+	}
+}
+
+// growInitWnd raises our advertised per-stream receive window to
+// newWnd (capped at MaxStreamRecvWindow, the actual size a new
+// stream's receive buffer is allocated to -- advertising more than
+// that would invite a well-behaved peer to send enough to overflow
+// it), telling the peer via SETTINGS and granting the difference to
+// every stream already open so it doesn't have to wait for the next
+// SYN_STREAM to benefit. This only ever makes the peer more willing
+// to send to us; it must not be confused with initwnd, which is the
+// peer's own grant to us and seeds what we're allowed to send on
+// streams we open.
+func (s *Session) growInitWnd(newWnd int32) {
+	if newWnd > s.MaxStreamRecvWindow {
+		newWnd = s.MaxStreamRecvWindow
+	}
+	if newWnd <= s.recvInitWnd {
+		return
+	}
+	delta := newWnd - s.recvInitWnd
+	s.recvInitWnd = newWnd
+	s.writeFrame(&SettingsFrame{FlagIdValues: []SettingsFlagIdValue{
+		{Id: SettingsInitialWindowSize, Value: uint32(newWnd)},
+	}})
+	for id := range s.streams {
+		s.writeFrame(&WindowUpdateFrame{StreamId: id, DeltaWindowSize: uint32(delta)})
+	}
+}
@@ -0,0 +1,57 @@
+package spdyframing
+
+import "testing"
+
+// discardRWC is a minimal io.ReadWriteCloser for tests that need a
+// working Session (and therefore a real Framer) but never actually
+// exchange frames with a peer: writes succeed and are discarded,
+// reads block forever. Safe as long as the test never starts Run.
+type discardRWC struct{}
+
+func (discardRWC) Read(p []byte) (int, error)  { select {} }
+func (discardRWC) Write(p []byte) (int, error) { return len(p), nil }
+func (discardRWC) Close() error                { return nil }
+
+func newBDPTestSession() *Session {
+	return NewSession(discardRWC{}, Options{})
+}
+
+func TestGrowInitWndCapsAtMaxStreamRecvWindow(t *testing.T) {
+	s := newBDPTestSession()
+	s.MaxStreamRecvWindow = defaultInitWnd
+	s.recvInitWnd = defaultInitWnd
+
+	s.growInitWnd(defaultInitWnd * 4)
+
+	if s.recvInitWnd != s.MaxStreamRecvWindow {
+		t.Fatalf("recvInitWnd = %d, want capped at MaxStreamRecvWindow (%d)", s.recvInitWnd, s.MaxStreamRecvWindow)
+	}
+}
+
+func TestGrowInitWndNoopOnceAtCap(t *testing.T) {
+	s := newBDPTestSession()
+	s.MaxStreamRecvWindow = defaultInitWnd * 2
+	s.recvInitWnd = defaultInitWnd
+
+	s.growInitWnd(defaultInitWnd * 2) // grows to the cap
+	before := s.recvInitWnd
+	s.growInitWnd(defaultInitWnd * 3) // nothing left to grant
+
+	if s.recvInitWnd != before {
+		t.Fatalf("recvInitWnd changed from %d to %d on a no-op growInitWnd call", before, s.recvInitWnd)
+	}
+}
+
+func TestGrowInitWndLeavesInitwndAlone(t *testing.T) {
+	// initwnd (the peer's own grant, used to seed sends on streams we
+	// open) must never move just because we grew our receive window.
+	s := newBDPTestSession()
+	s.MaxStreamRecvWindow = defaultInitWnd * 2
+	s.initwnd = defaultInitWnd
+
+	s.growInitWnd(defaultInitWnd * 2)
+
+	if s.initwnd != defaultInitWnd {
+		t.Fatalf("initwnd = %d, want unchanged at %d", s.initwnd, defaultInitWnd)
+	}
+}
@@ -0,0 +1,67 @@
+package spdyframing
+
+// This file implements SettingsMaxConcurrentStreams enforcement in
+// both directions: MaxConcurrentStreams bounds how many streams the
+// peer may have open on us, and the peer's own advertised limit
+// (learned via SETTINGS, see Session.set) bounds how many streams
+// we may have open on them, queuing any OpenContext calls beyond
+// that until a slot frees up.
+
+// removePendingOpen drops st from the open queue after its
+// OpenContext call was canceled. It is a no-op if st already made it
+// past the queue (or never entered it).
+func (s *Session) removePendingOpen(st *Stream) {
+	for i, pending := range s.pendingOpens {
+		if pending == st {
+			s.pendingOpens = append(s.pendingOpens[:i], s.pendingOpens[i+1:]...)
+			return
+		}
+	}
+}
+
+// admitPendingOpens initiates as many queued streams as the current
+// peerMaxConcurrentStreams allows. It's called whenever that limit
+// might have grown (onStreamClosed, Session.set), neither of which
+// checks s.closing itself, so a stream queued before closing began
+// must be failed here the same way the s.syn case fails one arriving
+// after closing began -- otherwise a slot freed during graceful
+// drain (or a SETTINGS bump) would send a brand-new SYN_STREAM to a
+// peer we already told, or that already told us, the session is
+// going away.
+func (s *Session) admitPendingOpens() {
+	for len(s.pendingOpens) > 0 {
+		st := s.pendingOpens[0]
+		if s.closing {
+			s.pendingOpens = s.pendingOpens[1:]
+			st.rclose(errClosed)
+			st.wclose(errClosed)
+			select {
+			case st.gotReply <- false:
+			default:
+			}
+			close(st.opened)
+			continue
+		}
+		if s.peerMaxConcurrentStreams != 0 && s.localOpenStreams >= s.peerMaxConcurrentStreams {
+			break
+		}
+		s.pendingOpens = s.pendingOpens[1:]
+		s.initiate(st)
+		s.localOpenStreams++
+	}
+}
+
+// onStreamClosed updates the open-stream counters once id is fully
+// closed (both directions) and, if id was locally initiated, admits
+// the next queued OpenContext call.
+func (s *Session) onStreamClosed(id StreamId) {
+	local := (id%2 == 0) == s.isServer
+	if local {
+		if s.localOpenStreams > 0 {
+			s.localOpenStreams--
+		}
+		s.admitPendingOpens()
+	} else if s.remoteOpenStreams > 0 {
+		s.remoteOpenStreams--
+	}
+}
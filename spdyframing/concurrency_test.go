@@ -0,0 +1,37 @@
+package spdyframing
+
+import "testing"
+
+func newConcurrencyTestSession(isServer bool) *Session {
+	return &Session{
+		isServer: isServer,
+		streams:  make(map[StreamId]*Stream),
+		wsched:   NewFIFOWriteScheduler(),
+	}
+}
+
+func TestAdmitPendingOpensSkipsQueueOnceClosing(t *testing.T) {
+	s := newConcurrencyTestSession(false)
+	s.peerMaxConcurrentStreams = 1
+	st := newStream(s, 0)
+	st.opened = make(chan struct{})
+	s.pendingOpens = []*Stream{st}
+	s.closing = true
+
+	s.admitPendingOpens()
+
+	if len(s.pendingOpens) != 0 {
+		t.Fatalf("pendingOpens still has %d entries, want the queue drained once closing", len(s.pendingOpens))
+	}
+	if !st.wclosed || !st.rclosed {
+		t.Fatal("admitPendingOpens did not fail a queued OpenContext call once closing")
+	}
+	select {
+	case <-st.opened:
+	default:
+		t.Fatal("admitPendingOpens did not close st.opened for a queued stream it refused to admit")
+	}
+	if s.localOpenStreams != 0 {
+		t.Fatalf("localOpenStreams = %d, want 0; a queued stream was admitted after closing began", s.localOpenStreams)
+	}
+}
@@ -0,0 +1,130 @@
+package spdyframing
+
+import "context"
+
+// This file implements connection-level flow control alongside the
+// per-stream flow control in session.go. SPDY/3 section 2.6.8
+// requires both: a slow reader on one stream must be able to exert
+// backpressure on the whole session, not just its own stream.
+
+// growConnSendWnd applies a connection-level WINDOW_UPDATE
+// (StreamId 0), waking any writer blocked in
+// reserveConnSendWndContext. It reports whether the delta was valid,
+// mirroring the per-stream check in handleWindowUpdate: a zero or
+// negative delta is always a protocol violation, and one that
+// overflows an already-positive window is too.
+func (s *Session) growConnSendWnd(delta int32) bool {
+	s.connSendCond.L.Lock()
+	prev := s.connSendWnd
+	s.connSendWnd += delta
+	ok := delta >= 1 && !(prev > 0 && s.connSendWnd < 0)
+	s.connSendCond.L.Unlock()
+	s.connSendCond.Broadcast()
+	return ok
+}
+
+// refundConnSendWnd returns n unused bytes of previously reserved
+// connection send window, e.g. when a per-stream limit ended up
+// shorter than what was reserved.
+func (s *Session) refundConnSendWnd(n int32) {
+	if n == 0 {
+		return
+	}
+	s.connSendCond.L.Lock()
+	s.connSendWnd += n
+	s.connSendCond.L.Unlock()
+	s.connSendCond.Broadcast()
+}
+
+// reserveConnSendWndContext blocks until the connection send window
+// is nonzero, then claims up to len(p) bytes of it, returning the
+// (possibly shorter) slice actually reserved. It returns ctx.Err() if
+// ctx is done, or st.wErr if st is closed for writing, before the
+// window is available -- otherwise a stream reset or GOAWAY-canceled
+// out from under a writer blocked here would never be woken, since
+// growConnSendWnd only Broadcasts on a connection-level
+// WINDOW_UPDATE. sync.Cond has no way to select on a channel, so a
+// helper goroutine turns either signal into a Broadcast that makes
+// the waiter re-check.
+func (s *Session) reserveConnSendWndContext(ctx context.Context, st *Stream, p []byte) ([]byte, error) {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-st.wstop:
+		case <-stop:
+			return
+		}
+		// Taking the lock first means the waiter is either still
+		// before its check or already inside Wait, so this Broadcast
+		// can't be lost.
+		s.connSendCond.L.Lock()
+		s.connSendCond.L.Unlock()
+		s.connSendCond.Broadcast()
+	}()
+
+	stopped := func() bool {
+		select {
+		case <-st.wstop:
+			return true
+		default:
+			return false
+		}
+	}
+
+	s.connSendCond.L.Lock()
+	for s.connSendWnd <= 0 && !s.connClosed && ctx.Err() == nil && !stopped() {
+		s.connSendCond.Wait()
+	}
+	if err := ctx.Err(); err != nil {
+		s.connSendCond.L.Unlock()
+		return nil, err
+	}
+	if stopped() {
+		s.connSendCond.L.Unlock()
+		return nil, st.wErr
+	}
+	if s.connClosed {
+		s.connSendCond.L.Unlock()
+		return nil, errClosed
+	}
+	if n := int(s.connSendWnd); n < len(p) {
+		p = p[:n]
+	}
+	s.connSendWnd -= int32(len(p))
+	s.connSendCond.L.Unlock()
+	return p, nil
+}
+
+// noteConnRecvData accounts for n bytes of DATA received, regardless
+// of which stream they arrived on. It reports whether the
+// connection-level receive window is still non-negative afterward;
+// a peer that keeps sending past it has violated connection-level
+// flow control.
+func (s *Session) noteConnRecvData(n int) bool {
+	s.connRecvMu.Lock()
+	s.connRecvWnd -= int32(n)
+	ok := s.connRecvWnd >= 0
+	s.connRecvMu.Unlock()
+	return ok
+}
+
+// noteConnRecvConsumed records that n bytes of connection-level
+// receive window were freed by the application (a Stream.Read
+// call). Once the freed total crosses MaxConnRecvWindow/2, it
+// returns the amount to grant back to the peer via a
+// connection-level WINDOW_UPDATE; otherwise it returns 0 and keeps
+// accumulating.
+func (s *Session) noteConnRecvConsumed(n int) int32 {
+	s.connRecvMu.Lock()
+	defer s.connRecvMu.Unlock()
+	s.connRecvUsed += int32(n)
+	if s.connRecvUsed < s.MaxConnRecvWindow/2 {
+		return 0
+	}
+	delta := s.connRecvUsed
+	s.connRecvUsed = 0
+	s.connRecvWnd += delta
+	return delta
+}
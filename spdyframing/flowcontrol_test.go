@@ -0,0 +1,80 @@
+package spdyframing
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newFlowControlTestSession() *Session {
+	return &Session{
+		streams:      make(map[StreamId]*Stream),
+		wsched:       NewFIFOWriteScheduler(),
+		connSendCond: sync.NewCond(new(sync.Mutex)),
+	}
+}
+
+func TestReserveConnSendWndContextWakesOnStreamClose(t *testing.T) {
+	s := newFlowControlTestSession()
+	st := newStream(s, 1) // connSendWnd is 0: the call below has nothing to reserve and must block
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := s.reserveConnSendWndContext(context.Background(), st, []byte("x"))
+		result <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond) // let the goroutine reach Wait()
+	st.wclose(resetError(Cancel))     // what handleRstStream/handleGoAway do to st
+
+	select {
+	case err := <-result:
+		if err != resetError(Cancel) {
+			t.Fatalf("err = %v, want resetError(Cancel) from the closed stream", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reserveConnSendWndContext did not wake up when its own stream closed out from under it")
+	}
+}
+
+func TestReserveConnSendWndContextWakesOnCtxDone(t *testing.T) {
+	s := newFlowControlTestSession()
+	st := newStream(s, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := s.reserveConnSendWndContext(ctx, st, []byte("x"))
+		result <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-result:
+		if err != context.Canceled {
+			t.Fatalf("err = %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reserveConnSendWndContext did not wake up when ctx was canceled")
+	}
+}
+
+func TestReserveConnSendWndContextReservesAvailableWindow(t *testing.T) {
+	s := newFlowControlTestSession()
+	s.connSendWnd = 3
+	st := newStream(s, 1)
+
+	p, err := s.reserveConnSendWndContext(context.Background(), st, []byte("hello"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(p) != 3 {
+		t.Fatalf("len(p) = %d, want capped at the 3 bytes of available connSendWnd", len(p))
+	}
+	if s.connSendWnd != 0 {
+		t.Fatalf("connSendWnd = %d, want 0 after reserving it all", s.connSendWnd)
+	}
+}
@@ -0,0 +1,179 @@
+package spdyframing
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// goAwayError is returned by a locally-initiated stream when the
+// peer's GOAWAY frame advertised a last-good-stream-id lower than
+// the stream's id. Callers may retry the request on a new session.
+type goAwayError StreamId
+
+func (e goAwayError) Error() string {
+	return fmt.Sprintf("stream canceled by peer GOAWAY (last good stream %d)", StreamId(e))
+}
+
+// Retryable reports whether the request that was sent on the
+// canceled stream is safe to redial on a new session.
+func (e goAwayError) Retryable() bool { return true }
+
+type shutdownRequest struct {
+	ctx context.Context
+}
+
+// Shutdown gracefully terminates the session: it sends a GOAWAY
+// frame advertising the last stream id successfully received,
+// refuses any further locally-initiated Open calls, and lets
+// streams already in flight run to completion. It returns once Run
+// has stopped (Run closes the transport as it returns), or once ctx
+// is done, whichever comes first. Calling Shutdown after Run has
+// already returned is a no-op; calling it again while streams are
+// still draining sends another GOAWAY.
+func (s *Session) Shutdown(ctx context.Context) error {
+	select {
+	case s.shutdown <- &shutdownRequest{ctx: ctx}:
+	case <-s.stopped:
+		return nil
+	}
+	select {
+	case <-s.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Session) watchShutdownTimeout(ctx context.Context, errCh chan<- error) {
+	select {
+	case <-ctx.Done():
+		select {
+		case errCh <- ctx.Err():
+		case <-s.stopped:
+		}
+	case <-s.stopped:
+	}
+}
+
+// goAwayStatus picks the GOAWAY status code to report when Run is
+// exiting because of err.
+func goAwayStatus(err error) GoAwayStatus {
+	if err == nil {
+		return GoAwayOK
+	}
+	return GoAwayInternalError
+}
+
+func (s *Session) handleGoAway(f *GoAwayFrame) {
+	// The peer is telling us it's shutting down; match the syn case's
+	// own closing check so Open/OpenContext start refusing instead of
+	// admitting streams that are doomed to be canceled on arrival.
+	s.closing = true
+	localParity := StreamId(1)
+	if s.isServer {
+		localParity = 0
+	}
+	for id, st := range s.streams {
+		if id%2 == localParity && id > f.LastGoodStreamId {
+			st.rclose(goAwayError(id))
+			st.wclose(goAwayError(id))
+			select {
+			case st.gotReply <- false:
+			default:
+			}
+			// st is now closed in both directions; perform the
+			// same cleanup writeFrame does for a stream that
+			// closes that way, since nothing will be writing to
+			// it again to trigger that path.
+			delete(s.streams, id)
+			s.wsched.CloseStream(id)
+			s.onStreamClosed(id)
+		}
+	}
+}
+
+type pingRequest struct {
+	ack chan error
+}
+
+// pingKind distinguishes why a PING was sent, so handlePing knows
+// what to do once it's acked.
+type pingKind int
+
+const (
+	pingKindUser pingKind = iota
+	pingKindBDP
+	pingKindKeepalive
+)
+
+// pendingPing is an outstanding PING we're waiting on an ack for.
+// Session.pendingPings may hold several at once: a caller's Ping(),
+// a BDP sample (bdp.go), and a keepalive probe (keepalive.go) can
+// all be in flight at the same time.
+type pendingPing struct {
+	sentAt time.Time
+	ack    chan error // nil for pings nobody is blocked waiting on
+	kind   pingKind
+}
+
+// Ping sends a PING frame and blocks until the peer acknowledges
+// it or the session closes. It mirrors the ping/ack coordination
+// used by HTTP/2 implementations to detect a dead connection or to
+// confirm the peer has processed frames sent just before a
+// Shutdown.
+func (s *Session) Ping() error {
+	req := &pingRequest{ack: make(chan error, 1)}
+	select {
+	case s.ping <- req:
+	case <-s.stopped:
+		return errClosed
+	}
+	select {
+	case err := <-req.ack:
+		return err
+	case <-s.stopped:
+		return errClosed
+	}
+}
+
+func (s *Session) sendPing(req *pingRequest) {
+	if _, err := s.sendTrackedPing(pingKindUser, req.ack); err != nil {
+		req.ack <- err
+	}
+}
+
+// sendTrackedPing writes a PING frame with a fresh id, recording it
+// in s.pendingPings so handlePing can recognize the ack.
+func (s *Session) sendTrackedPing(kind pingKind, ack chan error) (uint32, error) {
+	s.pingId += 2
+	id := s.pingId
+	s.pendingPings[id] = &pendingPing{sentAt: time.Now(), ack: ack, kind: kind}
+	if err := s.writeFrame(&PingFrame{Id: id}); err != nil {
+		delete(s.pendingPings, id)
+		return 0, err
+	}
+	return id, nil
+}
+
+// handlePing implements PING ack semantics: a PING whose id matches
+// one we sent ourselves (via Ping, a BDP probe, or a keepalive
+// probe) is an ack and resolves the waiter; any other PING was
+// initiated by the peer and must be echoed straight back, per
+// SPDY/3 section 2.6.5.
+func (s *Session) handlePing(f *PingFrame) error {
+	if pp, ok := s.pendingPings[f.Id]; ok {
+		delete(s.pendingPings, f.Id)
+		switch pp.kind {
+		case pingKindBDP:
+			s.bdpSampleDone(time.Since(pp.sentAt))
+		case pingKindKeepalive:
+			s.keepaliveAcked(f.Id)
+		}
+		if pp.ack != nil {
+			pp.ack <- nil
+		}
+		return nil
+	}
+	return s.writeFrame(f)
+}
@@ -0,0 +1,59 @@
+package spdyframing
+
+import "testing"
+
+func newGoAwayTestSession(isServer bool) *Session {
+	return &Session{
+		isServer: isServer,
+		streams:  make(map[StreamId]*Stream),
+		wsched:   NewFIFOWriteScheduler(),
+	}
+}
+
+func TestHandleGoAwayCancelsStreamsPastLastGoodStreamId(t *testing.T) {
+	s := newGoAwayTestSession(false) // client: locally-initiated streams are odd
+	local := newStream(s, 3)
+	s.streams[3] = local
+	s.localOpenStreams = 1
+
+	s.handleGoAway(&GoAwayFrame{LastGoodStreamId: 1})
+
+	if !local.rclosed || !local.wclosed {
+		t.Fatal("handleGoAway did not close the canceled stream in both directions")
+	}
+	if _, ok := s.streams[3]; ok {
+		t.Fatal("handleGoAway left a canceled stream in s.streams")
+	}
+	if s.localOpenStreams != 0 {
+		t.Fatalf("localOpenStreams = %d, want 0 once the only local stream was canceled", s.localOpenStreams)
+	}
+}
+
+func TestHandleGoAwayLeavesStreamsAtOrBelowLastGoodStreamId(t *testing.T) {
+	s := newGoAwayTestSession(false)
+	kept := newStream(s, 1)
+	s.streams[1] = kept
+	s.localOpenStreams = 1
+
+	s.handleGoAway(&GoAwayFrame{LastGoodStreamId: 1})
+
+	if _, ok := s.streams[1]; !ok {
+		t.Fatal("handleGoAway removed a stream at or below LastGoodStreamId")
+	}
+	if kept.rclosed || kept.wclosed {
+		t.Fatal("handleGoAway closed a stream at or below LastGoodStreamId")
+	}
+	if s.localOpenStreams != 1 {
+		t.Fatalf("localOpenStreams = %d, want unchanged at 1", s.localOpenStreams)
+	}
+}
+
+func TestHandleGoAwaySetsClosing(t *testing.T) {
+	s := newGoAwayTestSession(false)
+
+	s.handleGoAway(&GoAwayFrame{LastGoodStreamId: 0})
+
+	if !s.closing {
+		t.Fatal("handleGoAway did not set s.closing; Open would keep admitting new streams after a peer GOAWAY")
+	}
+}
@@ -0,0 +1,60 @@
+package spdyframing
+
+import (
+	"errors"
+	"time"
+)
+
+// This file implements idle-session timeouts and keepalive pings,
+// both driven by a periodic check against Session.lastActivity from
+// Run's main loop (see checkIdle).
+
+// keepaliveCheckInterval is how often Run polls lastActivity to
+// decide whether to send a keepalive PING or give up on one already
+// in flight. It's independent of IdleTimeout and
+// KeepalivePingInterval so both can be set to small values without
+// missing their deadline by a wide margin.
+const keepaliveCheckInterval = 1 * time.Second
+
+// keepaliveEnabled reports whether Run needs to start the periodic
+// checkIdle ticker at all.
+func (s *Session) keepaliveEnabled() bool {
+	return s.IdleTimeout > 0 || s.KeepalivePingInterval > 0
+}
+
+// checkIdle is called periodically from Run. It tears the session
+// down with an error if IdleTimeout has elapsed with no activity and
+// no open streams, sends a keepalive PING once the session has been
+// idle for KeepalivePingInterval, and reports an error if a keepalive
+// PING already in flight wasn't acked within KeepalivePingTimeout.
+// IdleTimeout is always checked, even with a keepalive in flight and
+// no KeepalivePingTimeout set to bound it: a caller that relies on
+// IdleTimeout alone as the backstop for a dead connection must not
+// have it disabled just because one unanswered probe is outstanding.
+func (s *Session) checkIdle() error {
+	now := time.Now()
+	if s.keepaliveInFlight != 0 && s.KeepalivePingTimeout > 0 && now.After(s.keepaliveDeadline) {
+		return errors.New("spdy: keepalive ping timed out")
+	}
+	idle := now.Sub(s.lastActivity)
+	if s.IdleTimeout > 0 && idle >= s.IdleTimeout && len(s.streams) == 0 {
+		return errors.New("spdy: session idle timeout")
+	}
+	if s.keepaliveInFlight == 0 && s.KeepalivePingInterval > 0 && idle >= s.KeepalivePingInterval {
+		id, err := s.sendTrackedPing(pingKindKeepalive, nil)
+		if err != nil {
+			return err
+		}
+		s.keepaliveInFlight = id
+		s.keepaliveDeadline = now.Add(s.KeepalivePingTimeout)
+	}
+	return nil
+}
+
+// keepaliveAcked is called from handlePing once the ack for a
+// keepalive PING arrives.
+func (s *Session) keepaliveAcked(id uint32) {
+	if s.keepaliveInFlight == id {
+		s.keepaliveInFlight = 0
+	}
+}
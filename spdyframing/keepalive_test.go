@@ -0,0 +1,68 @@
+package spdyframing
+
+import (
+	"testing"
+	"time"
+)
+
+func newKeepaliveTestSession() *Session {
+	return &Session{
+		pendingPings: make(map[uint32]*pendingPing),
+	}
+}
+
+func TestHandleReadSkipsLastActivityForKeepaliveAck(t *testing.T) {
+	s := newKeepaliveTestSession()
+	s.pendingPings[2] = &pendingPing{kind: pingKindKeepalive}
+	stale := time.Now().Add(-time.Hour)
+	s.lastActivity = stale
+
+	s.handleRead(&PingFrame{Id: 2})
+
+	if !s.lastActivity.Equal(stale) {
+		t.Fatalf("lastActivity = %v, want unchanged at %v; a keepalive ack must not look like activity", s.lastActivity, stale)
+	}
+}
+
+func TestHandleReadStampsLastActivityForNonKeepaliveFrames(t *testing.T) {
+	s := newKeepaliveTestSession()
+	stale := time.Now().Add(-time.Hour)
+	s.lastActivity = stale
+
+	s.handleRead(&SettingsFrame{})
+
+	if s.lastActivity.Equal(stale) {
+		t.Fatal("handleRead did not stamp lastActivity for a non-keepalive frame")
+	}
+}
+
+func TestCheckIdleFiresDespiteLiveKeepaliveTraffic(t *testing.T) {
+	s := newKeepaliveTestSession()
+	s.IdleTimeout = time.Millisecond
+	s.KeepalivePingInterval = time.Millisecond
+	s.pendingPings[2] = &pendingPing{kind: pingKindKeepalive}
+	s.lastActivity = time.Now().Add(-time.Hour)
+
+	// Simulate the read path acking the in-flight keepalive probe the
+	// way Run would; handleRead must not treat this as activity, or
+	// IdleTimeout would never see the session as idle.
+	s.handleRead(&PingFrame{Id: 2})
+
+	if err := s.checkIdle(); err == nil {
+		t.Fatal("checkIdle did not fire IdleTimeout despite a keepalive ack just having arrived")
+	}
+}
+
+func TestCheckIdleFiresWithUnackedKeepaliveAndNoPingTimeout(t *testing.T) {
+	s := newKeepaliveTestSession()
+	s.IdleTimeout = time.Millisecond
+	s.KeepalivePingInterval = time.Millisecond
+	// KeepalivePingTimeout is left at zero: "ping to keep NAT open,
+	// rely on IdleTimeout as the real backstop" is a valid config.
+	s.keepaliveInFlight = 2
+	s.lastActivity = time.Now().Add(-time.Hour)
+
+	if err := s.checkIdle(); err == nil {
+		t.Fatal("checkIdle did not fire IdleTimeout with an unacked keepalive in flight and no KeepalivePingTimeout set")
+	}
+}
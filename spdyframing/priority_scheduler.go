@@ -0,0 +1,111 @@
+package spdyframing
+
+// numPriorities is the number of SPDY stream priority levels (SPDY/3
+// section 2.3.1: 0 through 7).
+const numPriorities = 8
+
+// NewPriorityWriteScheduler returns a WriteScheduler that buckets
+// streams by their SYN_STREAM priority (0 highest, 7 lowest),
+// always draining a higher-priority bucket before a lower one, and
+// doing round-robin among the streams with queued frames within a
+// bucket. A stream with no AdjustStream call yet defaults to the
+// middle priority.
+func NewPriorityWriteScheduler() WriteScheduler {
+	return &priorityScheduler{
+		streamPriority: make(map[StreamId]PriorityParam),
+	}
+}
+
+type priorityScheduler struct {
+	buckets        [numPriorities]prioBucket
+	streamPriority map[StreamId]PriorityParam
+}
+
+// prioBucket holds the per-stream queues for one priority level and
+// the round-robin cursor used to divide writes evenly among the
+// streams that currently have frames ready.
+type prioBucket struct {
+	order []StreamId
+	cur   int
+	qs    map[StreamId][]FrameWriteRequest
+}
+
+func (b *prioBucket) push(id StreamId, r FrameWriteRequest) {
+	if b.qs == nil {
+		b.qs = make(map[StreamId][]FrameWriteRequest)
+	}
+	if _, ok := b.qs[id]; !ok {
+		b.order = append(b.order, id)
+	}
+	b.qs[id] = append(b.qs[id], r)
+}
+
+func (b *prioBucket) pop() (FrameWriteRequest, bool) {
+	for i := 0; i < len(b.order); i++ {
+		idx := (b.cur + i) % len(b.order)
+		id := b.order[idx]
+		q := b.qs[id]
+		if len(q) == 0 {
+			continue
+		}
+		r := q[0]
+		b.qs[id] = q[1:]
+		b.cur = (idx + 1) % len(b.order)
+		return r, true
+	}
+	return FrameWriteRequest{}, false
+}
+
+func (b *prioBucket) removeStream(id StreamId) {
+	delete(b.qs, id)
+	for i, sid := range b.order {
+		if sid == id {
+			b.order = append(b.order[:i], b.order[i+1:]...)
+			break
+		}
+	}
+	if b.cur >= len(b.order) {
+		b.cur = 0
+	}
+}
+
+func (s *priorityScheduler) priorityOf(id StreamId) PriorityParam {
+	if p, ok := s.streamPriority[id]; ok {
+		return p
+	}
+	return numPriorities / 2
+}
+
+func (s *priorityScheduler) Push(r FrameWriteRequest) {
+	p := s.priorityOf(r.StreamId)
+	s.buckets[p].push(r.StreamId, r)
+}
+
+func (s *priorityScheduler) Pop() (FrameWriteRequest, bool) {
+	for p := 0; p < numPriorities; p++ {
+		if r, ok := s.buckets[p].pop(); ok {
+			return r, true
+		}
+	}
+	return FrameWriteRequest{}, false
+}
+
+func (s *priorityScheduler) AdjustStream(id StreamId, priority PriorityParam) {
+	if priority >= numPriorities {
+		priority = numPriorities - 1
+	}
+	old := s.priorityOf(id)
+	s.streamPriority[id] = priority
+	if old != priority {
+		queued := s.buckets[old].qs[id]
+		s.buckets[old].removeStream(id)
+		for _, r := range queued {
+			s.buckets[priority].push(id, r)
+		}
+	}
+}
+
+func (s *priorityScheduler) CloseStream(id StreamId) {
+	s.buckets[s.priorityOf(id)].removeStream(id)
+	delete(s.streamPriority, id)
+}
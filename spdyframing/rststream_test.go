@@ -0,0 +1,38 @@
+package spdyframing
+
+import "testing"
+
+func newRstStreamTestSession(isServer bool) *Session {
+	return &Session{
+		isServer: isServer,
+		streams:  make(map[StreamId]*Stream),
+		wsched:   NewFIFOWriteScheduler(),
+	}
+}
+
+func TestHandleRstStreamClosesAndDecrementsOpenCount(t *testing.T) {
+	s := newRstStreamTestSession(false) // client: remote (peer-initiated) streams are even
+	st := newStream(s, 2)
+	s.streams[2] = st
+	s.remoteOpenStreams = 1
+
+	s.handleRstStream(&RstStreamFrame{StreamId: 2, Status: Cancel})
+
+	if !st.rclosed || !st.wclosed {
+		t.Fatal("handleRstStream did not close the reset stream in both directions")
+	}
+	if _, ok := s.streams[2]; ok {
+		t.Fatal("handleRstStream left the reset stream in s.streams")
+	}
+	if s.remoteOpenStreams != 0 {
+		t.Fatalf("remoteOpenStreams = %d, want 0 once the peer reset its only open stream", s.remoteOpenStreams)
+	}
+}
+
+func TestHandleRstStreamUnknownStreamIsNoop(t *testing.T) {
+	s := newRstStreamTestSession(false)
+
+	s.handleRstStream(&RstStreamFrame{StreamId: 9, Status: Cancel})
+	// No panic, and nothing to assert: resetting a stream we have no
+	// record of (already gone) must not create bookkeeping for it.
+}
@@ -0,0 +1,69 @@
+package spdyframing
+
+// PriorityParam is a stream's scheduling priority: 0 is highest and
+// 7 is lowest, matching the Priority field of SYN_STREAM (SPDY/3
+// section 2.3.1).
+type PriorityParam uint8
+
+// FrameWriteRequest is a single DATA frame queued to be written to
+// the wire through a Session's WriteScheduler.
+type FrameWriteRequest struct {
+	Frame    Frame
+	StreamId StreamId
+}
+
+// WriteScheduler decides the order in which queued DATA frames are
+// written to the wire. Every method is only ever called from a
+// Session's Run goroutine, so implementations need no internal
+// locking. Control frames (SYN_STREAM, SYN_REPLY, RST_STREAM, PING,
+// SETTINGS, GOAWAY, WINDOW_UPDATE) never pass through a
+// WriteScheduler; a Session writes those immediately so they can't
+// be held up behind queued DATA.
+type WriteScheduler interface {
+	// Push enqueues a DATA frame write request.
+	Push(FrameWriteRequest)
+	// Pop removes and returns the next frame to write, if any is
+	// queued.
+	Pop() (FrameWriteRequest, bool)
+	// AdjustStream sets the scheduling priority applied to id's
+	// queued and future frames.
+	AdjustStream(id StreamId, priority PriorityParam)
+	// CloseStream discards any state the scheduler holds for id.
+	CloseStream(id StreamId)
+}
+
+// NewFIFOWriteScheduler returns a WriteScheduler that writes frames
+// in the order they were pushed, ignoring stream priority. This
+// matches Session's behavior before WriteScheduler existed.
+func NewFIFOWriteScheduler() WriteScheduler {
+	return &fifoScheduler{}
+}
+
+type fifoScheduler struct {
+	q []FrameWriteRequest
+}
+
+func (f *fifoScheduler) Push(r FrameWriteRequest) {
+	f.q = append(f.q, r)
+}
+
+func (f *fifoScheduler) Pop() (FrameWriteRequest, bool) {
+	if len(f.q) == 0 {
+		return FrameWriteRequest{}, false
+	}
+	r := f.q[0]
+	f.q = f.q[1:]
+	return r, true
+}
+
+func (f *fifoScheduler) AdjustStream(StreamId, PriorityParam) {}
+
+func (f *fifoScheduler) CloseStream(id StreamId) {
+	kept := f.q[:0]
+	for _, r := range f.q {
+		if r.StreamId != id {
+			kept = append(kept, r)
+		}
+	}
+	f.q = kept
+}
@@ -0,0 +1,110 @@
+package spdyframing
+
+import "testing"
+
+func TestFifoSchedulerOrder(t *testing.T) {
+	s := NewFIFOWriteScheduler()
+	s.Push(FrameWriteRequest{StreamId: 1})
+	s.Push(FrameWriteRequest{StreamId: 2})
+	s.Push(FrameWriteRequest{StreamId: 1})
+
+	for _, want := range []StreamId{1, 2, 1} {
+		got, ok := s.Pop()
+		if !ok {
+			t.Fatalf("Pop() returned ok=false, want a frame for stream %d", want)
+		}
+		if got.StreamId != want {
+			t.Fatalf("Pop() = stream %d, want %d", got.StreamId, want)
+		}
+	}
+	if _, ok := s.Pop(); ok {
+		t.Fatal("Pop() on an empty scheduler returned ok=true")
+	}
+}
+
+func TestFifoSchedulerCloseStream(t *testing.T) {
+	s := NewFIFOWriteScheduler()
+	s.Push(FrameWriteRequest{StreamId: 1})
+	s.Push(FrameWriteRequest{StreamId: 2})
+	s.Push(FrameWriteRequest{StreamId: 1})
+	s.CloseStream(1)
+
+	got, ok := s.Pop()
+	if !ok || got.StreamId != 2 {
+		t.Fatalf("Pop() = (%v, %v), want (stream 2, true)", got, ok)
+	}
+	if _, ok := s.Pop(); ok {
+		t.Fatal("Pop() after CloseStream(1) returned a frame belonging to stream 1")
+	}
+}
+
+func TestPrioritySchedulerOrdersByPriority(t *testing.T) {
+	s := NewPriorityWriteScheduler()
+	s.AdjustStream(1, 7)
+	s.AdjustStream(2, 0)
+	s.Push(FrameWriteRequest{StreamId: 1})
+	s.Push(FrameWriteRequest{StreamId: 2})
+
+	got, ok := s.Pop()
+	if !ok || got.StreamId != 2 {
+		t.Fatalf("Pop() = (%v, %v), want the higher-priority stream 2 first", got, ok)
+	}
+	got, ok = s.Pop()
+	if !ok || got.StreamId != 1 {
+		t.Fatalf("Pop() = (%v, %v), want stream 1 second", got, ok)
+	}
+}
+
+func TestPrioritySchedulerRoundRobinsWithinBucket(t *testing.T) {
+	s := NewPriorityWriteScheduler()
+	s.Push(FrameWriteRequest{StreamId: 1})
+	s.Push(FrameWriteRequest{StreamId: 2})
+	s.Push(FrameWriteRequest{StreamId: 1})
+	s.Push(FrameWriteRequest{StreamId: 2})
+
+	for _, want := range []StreamId{1, 2, 1, 2} {
+		got, ok := s.Pop()
+		if !ok || got.StreamId != want {
+			t.Fatalf("Pop() = (%v, %v), want stream %d", got, ok, want)
+		}
+	}
+}
+
+func TestPrioritySchedulerAdjustStreamMovesQueuedFrames(t *testing.T) {
+	s := NewPriorityWriteScheduler()
+	s.AdjustStream(1, 5)
+	s.Push(FrameWriteRequest{StreamId: 1})
+	s.AdjustStream(1, 0)
+	s.Push(FrameWriteRequest{StreamId: 2})
+
+	// Stream 1's already-queued frame should have moved to the new
+	// (higher) priority bucket along with it, ahead of stream 2's
+	// default-priority frame.
+	got, ok := s.Pop()
+	if !ok || got.StreamId != 1 {
+		t.Fatalf("Pop() = (%v, %v), want stream 1's frame to have followed it to priority 0", got, ok)
+	}
+}
+
+func TestPrioritySchedulerCloseStreamDropsQueuedFrames(t *testing.T) {
+	s := NewPriorityWriteScheduler()
+	s.Push(FrameWriteRequest{StreamId: 1})
+	s.Push(FrameWriteRequest{StreamId: 2})
+	s.CloseStream(1)
+
+	got, ok := s.Pop()
+	if !ok || got.StreamId != 2 {
+		t.Fatalf("Pop() = (%v, %v), want stream 2's frame; stream 1 was closed", got, ok)
+	}
+	if _, ok := s.Pop(); ok {
+		t.Fatal("Pop() returned a frame after the only remaining stream was drained")
+	}
+}
+
+func TestPrioritySchedulerAdjustStreamClampsOutOfRange(t *testing.T) {
+	s := NewPriorityWriteScheduler().(*priorityScheduler)
+	s.AdjustStream(1, numPriorities+3)
+	if got := s.priorityOf(1); got != numPriorities-1 {
+		t.Fatalf("priorityOf(1) = %d, want clamped to %d", got, numPriorities-1)
+	}
+}
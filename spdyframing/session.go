@@ -1,22 +1,39 @@
 package spdyframing
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"sync"
+	"time"
 )
 
 // See SPDY/3 section 2.6.8.
 const defaultInitWnd = 64 * 1024
 
+// writeQueueDepth buffers Session.w so that stream goroutines
+// submitting DATA frames don't have to rendezvous with Run one at a
+// time. With an unbuffered channel, at most one FrameWriteRequest is
+// ever resident in the WriteScheduler when Run pops from it, so
+// competing streams' priorities never actually get compared. Letting
+// several submissions queue up lets drainPendingWrites hand Run's
+// WriteScheduler more than one real candidate to choose between.
+const writeQueueDepth = 64
+
 var (
 	errClosed      = errors.New("closed")
 	errNotReadable = errors.New("not readable")
 	errIsWritable  = errors.New("can't reply; already open for writing")
 	errNotWritable = errors.New("not writable; must reply first")
+
+	// errConnRecvOverflow and errConnWindowOverflow are session-level
+	// flow-control violations: unlike a per-stream violation, there's
+	// no single stream to reset, so they tear the whole session down.
+	errConnRecvOverflow   = errors.New("spdy: connection-level flow control violation")
+	errConnWindowOverflow = errors.New("spdy: connection-level window update overflow")
 )
 
 type resetError RstStreamStatus
@@ -34,24 +51,127 @@ type Session struct {
 	isServer   bool
 	streams    map[StreamId]*Stream
 	syn        chan *Stream
-	w          chan Frame
+	w          chan FrameWriteRequest
+	wsched     WriteScheduler
 	err        error
 	initwnd    int32
 	nextSynId  StreamId
 	lastRecvId StreamId
 	stopped    chan bool
+
+	// recvInitWnd is our own advertised per-stream receive window:
+	// the initial send credit we've told the peer, via SETTINGS,
+	// that new streams start with. bdp.go grows it based on observed
+	// bandwidth-delay product, measured from data the peer sends us.
+	// It's deliberately a separate field from initwnd, which is the
+	// peer's grant to us and seeds our own sends -- the two track
+	// opposite directions and must not be conflated.
+	recvInitWnd int32
+
+	closing  bool
+	shutdown chan *shutdownRequest
+
+	ping         chan *pingRequest
+	pingId       uint32
+	pendingPings map[uint32]*pendingPing
+
+	// IdleTimeout, if nonzero, tears the session down once no frames
+	// have flowed in either direction and no streams are open for
+	// this long: Run sends GOAWAY with GoAwayInternalError status and
+	// returns a non-nil error. It is not reported as a clean
+	// shutdown.
+	IdleTimeout time.Duration
+
+	// KeepalivePingInterval, if nonzero, sends a PING once the
+	// session has been otherwise idle for this long.
+	// KeepalivePingTimeout, if nonzero, tears the session down with
+	// an error if that PING isn't acked within this long.
+	KeepalivePingInterval time.Duration
+	KeepalivePingTimeout  time.Duration
+	lastActivity          time.Time
+	keepaliveInFlight     uint32
+	keepaliveDeadline     time.Time
+
+	// DisableBDPEstimation turns off the automatic bandwidth-delay-
+	// product probing that grows recvInitWnd (see bdp.go). It is
+	// checked as each DATA frame arrives; a sample already in flight
+	// when it is set still completes and can still grow recvInitWnd.
+	DisableBDPEstimation bool
+	bdpSampling          bool
+	bdpSampleBytes       int32
+	bdpMax               float64
+	bdpRTT               time.Duration
+	bdpLastSampleAt      time.Time
+
+	// MaxConnRecvWindow and MaxStreamRecvWindow size the
+	// connection-level and per-stream receive windows
+	// respectively. When Run starts it advertises
+	// MaxStreamRecvWindow via SETTINGS if it differs from the 64KiB
+	// default, and grows the connection window to MaxConnRecvWindow
+	// via WINDOW_UPDATE if it is larger; a smaller MaxConnRecvWindow
+	// does not shrink the peer's 64KiB connection grant. Both are
+	// also read later: MaxStreamRecvWindow sizes each new stream's
+	// receive buffer and caps BDP growth, and MaxConnRecvWindow sets
+	// when a connection-level WINDOW_UPDATE is sent. Changes made
+	// after Run starts are not re-advertised.
+	MaxConnRecvWindow   int32
+	MaxStreamRecvWindow int32
+
+	connSendWnd  int32
+	connClosed   bool
+	connSendCond *sync.Cond
+
+	connRecvMu   sync.Mutex
+	connRecvWnd  int32
+	connRecvUsed int32
+
+	// MaxConcurrentStreams caps the number of streams the peer may
+	// have open at once. It is advertised to the peer via SETTINGS
+	// when Run starts; zero means unlimited. Streams beyond the
+	// limit are refused with RST_STREAM(RefusedStream).
+	MaxConcurrentStreams uint32
+	remoteOpenStreams    uint32
+
+	peerMaxConcurrentStreams uint32
+	localOpenStreams         uint32
+	pendingOpens             []*Stream
+	cancelOpen               chan *Stream
 }
 
-// NewSession makes a new session on rwc.
-func NewSession(rwc io.ReadWriteCloser) *Session {
+// Options configures optional Session behavior.
+type Options struct {
+	// WriteScheduler controls the order in which queued DATA frames
+	// are written to the wire. A nil WriteScheduler selects
+	// NewFIFOWriteScheduler, matching the session's original
+	// behavior.
+	WriteScheduler WriteScheduler
+}
+
+// NewSession makes a new session on rwc using the given options.
+func NewSession(rwc io.ReadWriteCloser, opts Options) *Session {
+	wsched := opts.WriteScheduler
+	if wsched == nil {
+		wsched = NewFIFOWriteScheduler()
+	}
 	return &Session{
-		rwc:     rwc, // TODO(kr): buffer?
-		fr:      NewFramer(rwc, rwc),
-		initwnd: defaultInitWnd,
-		streams: make(map[StreamId]*Stream),
-		syn:     make(chan *Stream),
-		w:       make(chan Frame),
-		stopped: make(chan bool),
+		rwc:                 rwc, // TODO(kr): buffer?
+		fr:                  NewFramer(rwc, rwc),
+		initwnd:             defaultInitWnd,
+		recvInitWnd:         defaultInitWnd,
+		streams:             make(map[StreamId]*Stream),
+		syn:                 make(chan *Stream),
+		w:                   make(chan FrameWriteRequest, writeQueueDepth),
+		wsched:              wsched,
+		stopped:             make(chan bool),
+		shutdown:            make(chan *shutdownRequest),
+		ping:                make(chan *pingRequest),
+		MaxConnRecvWindow:   defaultInitWnd,
+		MaxStreamRecvWindow: defaultInitWnd,
+		connSendWnd:         defaultInitWnd,
+		connSendCond:        sync.NewCond(new(sync.Mutex)),
+		connRecvWnd:         defaultInitWnd,
+		cancelOpen:          make(chan *Stream),
+		pendingPings:        make(map[uint32]*pendingPing),
 	}
 }
 
@@ -68,6 +188,31 @@ func (s *Session) Run(server bool, f func(*Stream)) error {
 	} else {
 		s.nextSynId = 1
 	}
+	if server {
+		s.pingId = 2
+	} else {
+		s.pingId = 1
+	}
+	if s.MaxConcurrentStreams > 0 {
+		s.writeFrame(&SettingsFrame{FlagIdValues: []SettingsFlagIdValue{
+			{Id: SettingsMaxConcurrentStreams, Value: s.MaxConcurrentStreams},
+		}})
+	}
+	// MaxStreamRecvWindow and MaxConnRecvWindow are read here, once,
+	// so a caller that set them before Run has that value actually
+	// take effect instead of silently keeping the 64KiB default.
+	s.recvInitWnd = s.MaxStreamRecvWindow
+	if s.recvInitWnd != defaultInitWnd {
+		s.writeFrame(&SettingsFrame{FlagIdValues: []SettingsFlagIdValue{
+			{Id: SettingsInitialWindowSize, Value: uint32(s.recvInitWnd)},
+		}})
+	}
+	s.connRecvWnd = defaultInitWnd
+	if delta := s.MaxConnRecvWindow - defaultInitWnd; delta > 0 {
+		s.connRecvWnd += delta
+		s.writeFrame(&WindowUpdateFrame{StreamId: 0, DeltaWindowSize: uint32(delta)})
+	}
+	s.lastActivity = time.Now()
 	defer s.rwc.Close()
 	defer close(s.stopped)
 	defer func() {
@@ -79,6 +224,20 @@ func (s *Session) Run(server bool, f func(*Stream)) error {
 			default:
 			}
 		}
+		for _, pp := range s.pendingPings {
+			if pp.ack != nil {
+				pp.ack <- errClosed
+			}
+		}
+		for _, st := range s.pendingOpens {
+			st.rclose(errClosed)
+			st.wclose(errClosed)
+			close(st.opened)
+		}
+		s.connSendCond.L.Lock()
+		s.connClosed = true
+		s.connSendCond.L.Unlock()
+		s.connSendCond.Broadcast()
 	}()
 
 	r := make(chan Frame)
@@ -100,20 +259,60 @@ func (s *Session) Run(server bool, f func(*Stream)) error {
 		}
 	}()
 
+	var idleC <-chan time.Time
+	if s.keepaliveEnabled() {
+		idleTick := time.NewTicker(keepaliveCheckInterval)
+		defer idleTick.Stop()
+		idleC = idleTick.C
+	}
+
 	var err error
 	for {
 		select {
 		case f := <-r:
 			err = s.handleRead(f)
-		case f := <-s.w:
-			err = s.writeFrame(f)
+		case <-idleC:
+			err = s.checkIdle()
+		case req := <-s.w:
+			if _, ok := req.Frame.(*DataFrame); ok {
+				s.wsched.Push(req)
+				err = s.drainPendingWrites()
+			} else {
+				err = s.writeFrame(req.Frame)
+			}
 		case st := <-s.syn:
-			s.initiate(st)
+			if s.closing {
+				st.rclose(errClosed)
+				st.wclose(errClosed)
+				select {
+				case st.gotReply <- false:
+				default:
+				}
+				close(st.opened)
+			} else if s.peerMaxConcurrentStreams > 0 && s.localOpenStreams >= s.peerMaxConcurrentStreams {
+				s.pendingOpens = append(s.pendingOpens, st)
+			} else {
+				s.initiate(st)
+				s.localOpenStreams++
+			}
+		case st := <-s.cancelOpen:
+			s.removePendingOpen(st)
+		case req := <-s.ping:
+			s.sendPing(req)
+		case req := <-s.shutdown:
+			s.closing = true
+			err = s.writeFrame(&GoAwayFrame{LastGoodStreamId: s.lastRecvId})
+			go s.watchShutdownTimeout(req.ctx, errCh)
 		case err = <-errCh:
 		}
 
 		if err != nil {
-			// TODO(kr): send GOAWAY
+			if !s.closing {
+				s.writeFrame(&GoAwayFrame{LastGoodStreamId: s.lastRecvId, Status: goAwayStatus(err)})
+			}
+			break
+		}
+		if s.closing && len(s.streams) == 0 {
 			break
 		}
 	}
@@ -124,23 +323,39 @@ func (s *Session) Run(server bool, f func(*Stream)) error {
 }
 
 func (s *Session) handleRead(f Frame) error {
+	// An ack for our own keepalive probe arrives here too; stamping
+	// lastActivity for it would make checkIdle see the probe it just
+	// sent as proof of life, and IdleTimeout could never fire while
+	// KeepalivePingInterval < IdleTimeout -- the same bug writeFrame
+	// already guards against on the send side.
+	keepalive := false
+	if pf, ok := f.(*PingFrame); ok {
+		if pp, ok := s.pendingPings[pf.Id]; ok && pp.kind == pingKindKeepalive {
+			keepalive = true
+		}
+	}
+	if !keepalive {
+		s.lastActivity = time.Now()
+	}
 	switch f := f.(type) {
 	case *SynStreamFrame:
 		s.handleSynStream(f)
 	case *SynReplyFrame:
 		s.handleSynReply(f)
-	//case *RstStreamFrame:
+	case *RstStreamFrame:
+		s.handleRstStream(f)
 	case *SettingsFrame:
 		s.handleSettings(f)
 	case *PingFrame:
-		return s.writeFrame(f)
-	//case *GoAwayFrame:
+		return s.handlePing(f)
+	case *GoAwayFrame:
+		s.handleGoAway(f)
 	//case *HeadersFrame:
 	case *WindowUpdateFrame:
-		s.handleWindowUpdate(f)
+		return s.handleWindowUpdate(f)
 	//case *CredentialFrame:
 	case *DataFrame:
-		s.handleData(f)
+		return s.handleData(f)
 	default:
 		log.Println("spdy: ignoring unhandled frame:", f)
 	}
@@ -159,6 +374,9 @@ func (s *Session) set(id SettingsId, val uint32) {
 		if val < 1<<31 {
 			s.initwnd = int32(val)
 		}
+	case SettingsMaxConcurrentStreams:
+		s.peerMaxConcurrentStreams = val
+		s.admitPendingOpens()
 	}
 }
 
@@ -166,11 +384,15 @@ func (s *Session) handleSynStream(f *SynStreamFrame) {
 	fromServer := f.StreamId%2 == 0
 	if s.isServer == fromServer || f.StreamId <= s.lastRecvId {
 		s.resetStream(f.StreamId, ProtocolError)
+	} else if s.MaxConcurrentStreams > 0 && s.remoteOpenStreams >= s.MaxConcurrentStreams {
+		s.resetStream(f.StreamId, RefusedStream)
 	} else {
 		s.lastRecvId = f.StreamId
 		st := newStream(s, f.StreamId)
 		st.inHeader = f.Headers
 		s.streams[f.StreamId] = st
+		s.remoteOpenStreams++
+		s.wsched.AdjustStream(f.StreamId, PriorityParam(f.Priority))
 		if f.CFHeader.Flags&ControlFlagUnidirectional != 0 {
 			st.wclose(errClosed)
 		}
@@ -198,60 +420,136 @@ func (s *Session) initiate(st *Stream) {
 	st.id = s.nextSynId
 	s.nextSynId += 2
 	s.streams[st.id] = st
-	f := &SynStreamFrame{StreamId: st.id, Headers: st.outHeader}
+	s.wsched.AdjustStream(st.id, st.outPriority)
+	f := &SynStreamFrame{StreamId: st.id, Headers: st.outHeader, Priority: uint8(st.outPriority)}
 	st.setId <- true
 	f.CFHeader.Flags = st.outFlag
 	f.CFHeader.Flags &= ControlFlagUnidirectional | ControlFlagFin
 	s.writeFrame(f)
+	close(st.opened)
+}
+
+// drainPendingWrites empties s.w of any write requests that are
+// already buffered -- submitted by stream goroutines that didn't
+// have to wait for Run to receive them, thanks to s.w's buffer --
+// before handing off to flushWrites. Every DATA frame found along
+// the way is pushed into wsched so it actually competes against
+// whichever frame triggered this call; a non-DATA frame is written
+// immediately, in the same relative order Run would have handled it
+// in on its own. Without this, flushWrites would only ever see the
+// one frame that triggered it, and WriteScheduler's priority order
+// could never matter.
+func (s *Session) drainPendingWrites() error {
+	for {
+		select {
+		case req := <-s.w:
+			if _, ok := req.Frame.(*DataFrame); ok {
+				s.wsched.Push(req)
+				continue
+			}
+			if err := s.writeFrame(req.Frame); err != nil {
+				return err
+			}
+		default:
+			return s.flushWrites()
+		}
+	}
+}
+
+// flushWrites drains as many scheduled DATA frames as are currently
+// queued, stopping at the first write error.
+func (s *Session) flushWrites() error {
+	for {
+		req, ok := s.wsched.Pop()
+		if !ok {
+			return nil
+		}
+		if err := s.writeFrame(req.Frame); err != nil {
+			return err
+		}
+	}
 }
 
-func (s *Session) handleWindowUpdate(f *WindowUpdateFrame) {
+func (s *Session) handleWindowUpdate(f *WindowUpdateFrame) error {
+	if f.StreamId == 0 {
+		if !s.growConnSendWnd(int32(f.DeltaWindowSize)) {
+			return errConnWindowOverflow
+		}
+		return nil
+	}
 	st := s.streams[f.StreamId]
 	if st == nil {
 		// Ignore WINDOW_UPDATE that comes after we send FLAG_FIN.
 		// See SPDY/3 section 2.6.8.
-		return
+		return nil
 	}
 	delta := int32(f.DeltaWindowSize)
 	ok := true
-	st.wszCond.L.Lock()
+	st.wMu.Lock()
 	prev := st.wndSize
 	st.wndSize += delta
 	if delta < 1 || (prev > 0 && st.wndSize < 0) {
 		ok = false
 	}
-	st.wszCond.L.Unlock()
-	st.wszCond.Signal()
+	st.wMu.Unlock()
+	st.wWake.signal()
 	if !ok {
 		s.resetStream(f.StreamId, FlowControlError)
 	}
+	return nil
 }
 
-func (s *Session) handleData(f *DataFrame) {
+func (s *Session) handleData(f *DataFrame) error {
 	st := s.streams[f.StreamId]
 	if st == nil {
 		s.resetStream(f.StreamId, InvalidStream)
-		return
+		return nil
 	}
 	if st.rclosed {
 		s.resetStream(f.StreamId, StreamAlreadyClosed)
-		return
+		return nil
+	}
+	s.bdpOnData(len(f.Data))
+	if !s.noteConnRecvData(len(f.Data)) {
+		return errConnRecvOverflow
 	}
-	st.bufCond.L.Lock()
+	st.rMu.Lock()
 	_, err := st.buf.Write(f.Data)
-	st.bufCond.L.Unlock()
-	st.bufCond.Signal()
+	st.rMu.Unlock()
+	st.rWake.signal()
 	if f.Flags&DataFlagFin != 0 {
 		st.rclose(io.EOF)
 	}
 	if err != nil {
 		s.resetStream(f.StreamId, FlowControlError)
 	}
+	return nil
+}
+
+// handleRstStream processes a peer-initiated RST_STREAM. It must
+// perform the same cleanup writeFrame does for a locally-sent reset
+// -- rclose/wclose both directions, then delete the now fully-closed
+// stream from s.streams and run wsched.CloseStream/onStreamClosed --
+// since nothing will be writing to this stream again to trigger that
+// path. Without it, a peer that aborts a stream with RST_STREAM
+// leaves it in s.streams forever and the open-stream counters
+// MaxConcurrentStreams relies on never go back down.
+func (s *Session) handleRstStream(f *RstStreamFrame) {
+	st := s.streams[f.StreamId]
+	if st == nil {
+		return
+	}
+	st.rclose(resetError(f.Status))
+	st.wclose(resetError(f.Status))
+	delete(s.streams, f.StreamId)
+	s.wsched.CloseStream(f.StreamId)
+	s.onStreamClosed(f.StreamId)
 }
 
 func (s *Session) writeFrame(f Frame) error {
 	var st *Stream
 	fin := false
+	keepalive := false
 	switch f := f.(type) {
 	case *SynStreamFrame:
 		st = s.streams[f.StreamId]
@@ -266,7 +564,15 @@ func (s *Session) writeFrame(f Frame) error {
 			st.wclose(resetError(f.Status))
 		}
 	//case *SettingsFrame:
-	//case *PingFrame:
+	case *PingFrame:
+		// A keepalive probe is sent precisely because the session
+		// looked idle; letting it stamp lastActivity would make
+		// checkIdle see its own probe as proof of life and
+		// IdleTimeout would never fire while keepalives keep going
+		// out.
+		if pp, ok := s.pendingPings[f.Id]; ok && pp.kind == pingKindKeepalive {
+			keepalive = true
+		}
 	//case *GoAwayFrame:
 	case *HeadersFrame:
 		st = s.streams[f.StreamId]
@@ -281,12 +587,17 @@ func (s *Session) writeFrame(f Frame) error {
 	if err != nil {
 		log.Println("spdy: write error:", err)
 	}
+	if !keepalive {
+		s.lastActivity = time.Now()
+	}
 	if st != nil {
 		if fin {
 			st.wclose(errClosed)
 		}
 		if st.rclosed && st.wclosed {
 			delete(s.streams, st.id)
+			s.wsched.CloseStream(st.id)
+			s.onStreamClosed(st.id)
 		}
 	}
 	return nil
@@ -294,23 +605,25 @@ func (s *Session) writeFrame(f Frame) error {
 
 func (s *Stream) rclose(err error) {
 	if !s.rclosed {
-		s.bufCond.L.Lock()
+		s.rMu.Lock()
 		s.rclosed = true
 		s.rErr = err
 		s.buf.Close()
-		s.bufCond.L.Unlock()
-		s.bufCond.Signal()
+		s.rMu.Unlock()
+		s.rWake.signal()
+		s.closeContext()
 	}
 }
 
 func (s *Stream) wclose(err error) {
 	if !s.wclosed {
-		s.wszCond.L.Lock()
+		s.wMu.Lock()
 		s.wclosed = true
 		s.wErr = err
-		s.wszCond.L.Unlock()
-		s.wszCond.Signal()
+		s.wMu.Unlock()
+		s.wWake.signal()
 		close(s.wstop)
+		s.closeContext()
 	}
 }
 
@@ -319,15 +632,27 @@ func (s *Session) resetStream(id StreamId, status RstStreamStatus) error {
 }
 
 // Open initiates a new SPDY stream with SYN_STREAM.
-// Flags invalid for SYN_STREAM will be silently ignored.
-func (s *Session) Open(h http.Header, flag ControlFlags) (*Stream, error) {
+// Flags invalid for SYN_STREAM will be silently ignored. priority
+// sets the stream's scheduling priority (0 highest, 7 lowest); see
+// WriteScheduler. Open blocks if the peer's advertised
+// SettingsMaxConcurrentStreams limit is currently reached; use
+// OpenContext to bound that wait.
+func (s *Session) Open(h http.Header, flag ControlFlags, priority PriorityParam) (*Stream, error) {
+	return s.OpenContext(context.Background(), h, flag, priority)
+}
+
+// OpenContext is like Open, but returns ctx.Err() if ctx is done
+// before a stream slot is available.
+func (s *Session) OpenContext(ctx context.Context, h http.Header, flag ControlFlags, priority PriorityParam) (*Stream, error) {
 	st := newStream(s, 0)
 	st.outHeader = h
 	st.outFlag = flag
+	st.outPriority = priority
 	st.gotReply = make(chan bool, 1)
 	st.wready = true
 	st.setId = make(chan bool, 1)
 	st.needId = true
+	st.opened = make(chan struct{})
 	if flag&ControlFlagUnidirectional != 0 {
 		st.rclose(errNotReadable)
 	} else {
@@ -338,7 +663,40 @@ func (s *Session) Open(h http.Header, flag ControlFlags) (*Stream, error) {
 	case <-s.stopped:
 		return nil, errors.New("session closed")
 	}
-	return st, nil
+	select {
+	case <-st.opened:
+		return st, nil
+	case <-s.stopped:
+		return nil, errors.New("session closed")
+	case <-ctx.Done():
+		select {
+		case s.cancelOpen <- st:
+			// s.cancelOpen is always one of Run's select cases, so
+			// this send can succeed even when Run already admitted
+			// st (closing st.opened) in an earlier iteration --
+			// removePendingOpen is then just a harmless no-op on
+			// Run's side. Which branch fired here is decided by
+			// Go's random select, not by which happened first, so
+			// check st.opened's actual state rather than trusting
+			// it: if Run already closed it, the admission
+			// happens-before this send's rendezvous, so the
+			// non-blocking receive below is guaranteed to see it.
+			select {
+			case <-st.opened:
+				return st, nil
+			default:
+				return nil, ctx.Err()
+			}
+		case <-st.opened:
+			// Run admitted st (closing st.opened) in the same
+			// instant ctx was canceled; the stream is already
+			// live and peer-visible, so hand it back instead of
+			// discarding the caller's only handle to it.
+			return st, nil
+		case <-s.stopped:
+			return nil, errors.New("session closed")
+		}
+	}
 }
 
 // Stream represents a stream in the low-level SPDY framing layer.
@@ -353,40 +711,65 @@ type Stream struct {
 	// again.
 	//Trailer http.Header
 
-	outHeader http.Header // outgoing SYN_STREAM
-	outFlag   ControlFlags
-	gotReply  chan bool
-	needReply bool
-	setId     chan bool
-	needId    bool
+	outHeader   http.Header // outgoing SYN_STREAM
+	outFlag     ControlFlags
+	outPriority PriorityParam
+	gotReply    chan bool
+	needReply   bool
+	setId       chan bool
+	needId      bool
+	opened      chan struct{} // closed once initiate runs, or Open is refused
 
 	id      StreamId
 	sess    *Session
+	rMu     sync.Mutex
 	buf     buffer // incoming data
-	bufCond *sync.Cond
+	rWake   *wake
 	wready  bool
 	rclosed bool
 	wclosed bool
 	rErr    error
 	wErr    error
+	wMu     sync.Mutex
 	wndSize int32 // send window size
-	wszCond *sync.Cond
+	wWake   *wake
 	wstop   chan bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func newStream(sess *Session, id StreamId) *Stream {
+	ctx, cancel := context.WithCancel(context.Background())
 	s := &Stream{
 		id:      id,
 		sess:    sess,
-		buf:     buffer{buf: make([]byte, defaultInitWnd)},
-		bufCond: sync.NewCond(new(sync.Mutex)),
+		buf:     buffer{buf: make([]byte, sess.MaxStreamRecvWindow)},
+		rWake:   newWake(),
 		wndSize: sess.initwnd,
-		wszCond: sync.NewCond(new(sync.Mutex)),
+		wWake:   newWake(),
 		wstop:   make(chan bool),
+		ctx:     ctx,
+		cancel:  cancel,
 	}
 	return s
 }
 
+// Context returns a context that is canceled once s is closed for
+// both reading and writing, for any reason: a local Close or Reset,
+// an RST_STREAM or GOAWAY from the peer, or the session ending.
+func (s *Stream) Context() context.Context {
+	return s.ctx
+}
+
+// closeContext cancels s.ctx once both directions have closed. It's
+// called from rclose and wclose, whichever runs second.
+func (s *Stream) closeContext() {
+	if s.rclosed && s.wclosed {
+		s.cancel()
+	}
+}
+
 // Incoming header, from either SYN_STREAM or SYN_REPLY.
 // Returns nil if there is no incoming direction (either
 // because s is unidirectional, or because of an error).
@@ -417,15 +800,34 @@ func (s *Stream) Reply(h http.Header, flag ControlFlags) error {
 
 // Read reads the contents of DATA frames received on s.
 func (s *Stream) Read(p []byte) (n int, err error) {
-	s.bufCond.L.Lock()
+	return s.ReadContext(context.Background(), p)
+}
+
+// ReadContext is like Read, but returns ctx.Err() if ctx is done
+// before any data arrives. On cancellation it also sends
+// RST_STREAM(Cancel), since there is no other way to wake a peer
+// blocked writing to a stream nobody is reading anymore.
+func (s *Stream) ReadContext(ctx context.Context, p []byte) (n int, err error) {
+	s.rMu.Lock()
 	for s.buf.Len() == 0 && !s.buf.closed {
-		s.bufCond.Wait()
+		wake := s.rWake.wait()
+		s.rMu.Unlock()
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			s.Reset(Cancel)
+			return 0, ctx.Err()
+		}
+		s.rMu.Lock()
 	}
 	n, err = s.buf.Read(p)
-	if err != nil {
-	}
-	s.bufCond.L.Unlock()
+	s.rMu.Unlock()
 	s.updateWindow(n)
+	if n > 0 {
+		if delta := s.sess.noteConnRecvConsumed(n); delta > 0 {
+			s.writeFrame(&WindowUpdateFrame{StreamId: 0, DeltaWindowSize: uint32(delta)})
+		}
+	}
 	if err == io.EOF {
 		err = s.rErr
 	}
@@ -446,32 +848,67 @@ func (s *Stream) updateWindow(delta int) error {
 // It is an error to call Write before calling Reply on a stream
 // initiated by the remote endpoint.
 func (s *Stream) Write(p []byte) (n int, err error) {
+	return s.WriteContext(context.Background(), p)
+}
+
+// WriteContext is like Write, but returns ctx.Err() if ctx is done
+// before the write completes. On cancellation it also sends
+// RST_STREAM(Cancel).
+func (s *Stream) WriteContext(ctx context.Context, p []byte) (n int, err error) {
 	var c int
 	for n < len(p) && err == nil {
-		c, err = s.writeOnce(p[n:])
+		c, err = s.writeOnceContext(ctx, p[n:])
 		n += c
 	}
 	return n, err
 }
 
-// writeOnce writes bytes from p as the contents of a single DATA frame.
-func (s *Stream) writeOnce(p []byte) (n int, err error) {
+// writeOnceContext writes bytes from p as the contents of a single
+// DATA frame.
+func (s *Stream) writeOnceContext(ctx context.Context, p []byte) (n int, err error) {
 	if !s.wready {
 		return 0, errNotWritable
 	}
-	s.wszCond.L.Lock()
+	s.wMu.Lock()
 	for s.wndSize <= 0 && !s.wclosed {
-		s.wszCond.Wait()
+		wake := s.wWake.wait()
+		s.wMu.Unlock()
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			s.Reset(Cancel)
+			return 0, ctx.Err()
+		}
+		s.wMu.Lock()
 	}
 	if s.wclosed {
-		s.wszCond.L.Unlock()
+		s.wMu.Unlock()
 		return 0, s.wErr
 	}
 	if n := int(s.wndSize); n < len(p) {
 		p = p[:n]
 	}
 	s.wndSize -= int32(len(p))
-	s.wszCond.L.Unlock()
+	s.wMu.Unlock()
+
+	reserved := len(p)
+	p, err = s.sess.reserveConnSendWndContext(ctx, s, p)
+	if err != nil {
+		s.wMu.Lock()
+		s.wndSize += int32(reserved)
+		s.wMu.Unlock()
+		s.wWake.signal()
+		if err == ctx.Err() {
+			s.Reset(Cancel)
+		}
+		return 0, err
+	}
+	if len(p) < reserved {
+		s.wMu.Lock()
+		s.wndSize += int32(reserved - len(p))
+		s.wMu.Unlock()
+		s.wWake.signal()
+	}
 
 	if s.needId {
 		<-s.setId
@@ -479,6 +916,7 @@ func (s *Stream) writeOnce(p []byte) (n int, err error) {
 	}
 	err = s.writeFrame(&DataFrame{StreamId: s.id, Data: p})
 	if err != nil {
+		s.sess.refundConnSendWnd(int32(len(p)))
 		return 0, err
 	}
 	return len(p), nil
@@ -506,8 +944,9 @@ func (s *Stream) Reset(status RstStreamStatus) error {
 }
 
 func (s *Stream) writeFrame(f Frame) error {
+	req := FrameWriteRequest{Frame: f, StreamId: s.id}
 	select {
-	case s.sess.w <- f:
+	case s.sess.w <- req:
 		return nil
 	case <-s.wstop:
 		return s.wErr
@@ -0,0 +1,34 @@
+package spdyframing
+
+import "sync"
+
+// wake is a broadcast signal used in place of sync.Cond for state
+// that a waiter may need to give up on early, e.g. because a
+// context was canceled. Unlike sync.Cond.Wait, waiting on the
+// channel returned by wait can be combined with other select cases.
+type wake struct {
+	mu sync.Mutex
+	c  chan struct{}
+}
+
+func newWake() *wake {
+	return &wake{c: make(chan struct{})}
+}
+
+// wait returns the channel to select on. It must be re-fetched after
+// every wakeup: the channel returned here is closed by the next call
+// to signal and replaced with a fresh one.
+func (w *wake) wait() <-chan struct{} {
+	w.mu.Lock()
+	c := w.c
+	w.mu.Unlock()
+	return c
+}
+
+// signal wakes every current waiter.
+func (w *wake) signal() {
+	w.mu.Lock()
+	close(w.c)
+	w.c = make(chan struct{})
+	w.mu.Unlock()
+}
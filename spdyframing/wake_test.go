@@ -0,0 +1,67 @@
+package spdyframing
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWakeSignalWakesWaiter(t *testing.T) {
+	w := newWake()
+	c := w.wait()
+	select {
+	case <-c:
+		t.Fatal("wait() channel was already closed before signal")
+	default:
+	}
+	w.signal()
+	select {
+	case <-c:
+	case <-time.After(time.Second):
+		t.Fatal("signal() did not close the channel returned by wait()")
+	}
+}
+
+func TestWakeWaitMustBeRefetchedAfterSignal(t *testing.T) {
+	w := newWake()
+	c1 := w.wait()
+	w.signal()
+	c2 := w.wait()
+	if c1 == c2 {
+		t.Fatal("wait() returned the same channel before and after signal()")
+	}
+	select {
+	case <-c2:
+		t.Fatal("channel from wait() after signal() was already closed")
+	default:
+	}
+}
+
+func TestWakeSignalWakesAllConcurrentWaiters(t *testing.T) {
+	w := newWake()
+	const n = 20
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			<-w.wait()
+		}()
+	}
+	// Give the goroutines a chance to call wait() before we signal,
+	// so this actually exercises the broadcast-to-many path rather
+	// than a single waiter race.
+	time.Sleep(10 * time.Millisecond)
+	w.signal()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("signal() did not wake all concurrent waiters")
+	}
+}